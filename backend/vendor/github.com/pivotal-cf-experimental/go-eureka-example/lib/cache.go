@@ -0,0 +1,372 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCacheRefreshInterval is how often StartCache refreshes the local
+// registry cache when CacheRefreshInterval is unset.
+const DefaultCacheRefreshInterval = 30 * time.Second
+
+// registryCache holds the consumer-side view of the Eureka registry built
+// by StartCache: a full fetch of /eureka/apps followed by incremental
+// /eureka/apps/delta fetches, so that Applications, AppByName, and Watch
+// can be served without a network round trip.
+type registryCache struct {
+	mu       sync.RWMutex
+	apps     map[string][]Instance
+	hashcode string
+
+	watchMu  sync.Mutex
+	watchers map[string][]chan []Instance
+}
+
+func newRegistryCache() *registryCache {
+	return &registryCache{
+		apps:     map[string][]Instance{},
+		watchers: map[string][]chan []Instance{},
+	}
+}
+
+type applicationsResp struct {
+	Applications struct {
+		AppsHashcode string        `json:"apps__hashcode"`
+		Application  []Application `json:"application"`
+	} `json:"applications"`
+}
+
+// StartCache performs an initial full fetch of the registry and then
+// refreshes it every CacheRefreshInterval, preferring cheap delta fetches
+// and falling back to a full fetch whenever a delta's apps__hashcode
+// disagrees with the merged result. It returns once the initial fetch has
+// completed; the refresh loop runs in the background until the context is
+// cancelled or StopCache is called.
+func (e *EurekaClient) StartCache(ctx context.Context) error {
+	e.cacheMu.Lock()
+	if e.cache == nil {
+		e.cache = newRegistryCache()
+	}
+	if e.cacheStopCh != nil {
+		e.cacheMu.Unlock()
+		return fmt.Errorf("eureka client cache already started")
+	}
+	e.cacheStopCh = make(chan struct{})
+	stopCh := e.cacheStopCh
+	e.cacheMu.Unlock()
+
+	if err := e.fetchFullRegistry(); err != nil {
+		e.cacheMu.Lock()
+		if e.cacheStopCh == stopCh {
+			e.cacheStopCh = nil
+		}
+		e.cacheMu.Unlock()
+		return err
+	}
+
+	interval := e.CacheRefreshInterval
+	if interval == 0 {
+		interval = DefaultCacheRefreshInterval
+	}
+
+	e.cacheWg.Add(1)
+	go e.refreshCache(ctx, interval, stopCh)
+
+	return nil
+}
+
+// StopCache halts the refresh loop started by StartCache.
+func (e *EurekaClient) StopCache() error {
+	e.cacheMu.Lock()
+	stopCh := e.cacheStopCh
+	e.cacheStopCh = nil
+	e.cacheMu.Unlock()
+
+	if stopCh == nil {
+		return nil
+	}
+	close(stopCh)
+	e.cacheWg.Wait()
+	return nil
+}
+
+func (e *EurekaClient) refreshCache(ctx context.Context, interval time.Duration, stopCh chan struct{}) {
+	defer e.cacheWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.fetchDeltaRegistry(); err != nil {
+				e.fetchFullRegistry()
+			}
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Applications returns a snapshot of every cached application's instances,
+// keyed by application name.
+func (e *EurekaClient) Applications() map[string][]Instance {
+	e.cacheMu.Lock()
+	cache := e.cache
+	e.cacheMu.Unlock()
+	if cache == nil {
+		return map[string][]Instance{}
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	apps := make(map[string][]Instance, len(cache.apps))
+	for name, instances := range cache.apps {
+		apps[name] = append([]Instance(nil), instances...)
+	}
+	return apps
+}
+
+// AppByName returns the cached instances for name, without hitting the
+// network.
+func (e *EurekaClient) AppByName(name string) []Instance {
+	e.cacheMu.Lock()
+	cache := e.cache
+	e.cacheMu.Unlock()
+	if cache == nil {
+		return nil
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return append([]Instance(nil), cache.apps[name]...)
+}
+
+// Watch returns a channel that receives the full instance list for name
+// every time the cache refresh observes a change for that application.
+// A watcher that has not read the previous list receives only the latest
+// one. The channel is never closed; callers that no longer need updates should
+// simply stop reading from it.
+func (e *EurekaClient) Watch(name string) <-chan []Instance {
+	e.cacheMu.Lock()
+	if e.cache == nil {
+		e.cache = newRegistryCache()
+	}
+	cache := e.cache
+	e.cacheMu.Unlock()
+
+	ch := make(chan []Instance, 1)
+
+	cache.watchMu.Lock()
+	cache.watchers[name] = append(cache.watchers[name], ch)
+	cache.watchMu.Unlock()
+
+	return ch
+}
+
+func (e *EurekaClient) fetchFullRegistry() error {
+	token, err := e.UAAClient.GetToken()
+	if err != nil {
+		return err
+	}
+
+	resp, respBytes, err := e.do("GET", "/eureka/apps", nil, func(req *http.Request) {
+		req.Header.Set("Accept", e.Encoding.contentType())
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: respBytes}
+	}
+
+	apps, hashcode, err := e.decodeApplications(respBytes)
+	if err != nil {
+		return err
+	}
+
+	e.cacheMu.Lock()
+	if e.cache == nil {
+		e.cache = newRegistryCache()
+	}
+	cache := e.cache
+	e.cacheMu.Unlock()
+
+	cache.replace(apps, hashcode)
+	return nil
+}
+
+func (e *EurekaClient) fetchDeltaRegistry() error {
+	token, err := e.UAAClient.GetToken()
+	if err != nil {
+		return err
+	}
+
+	resp, respBytes, err := e.do("GET", "/eureka/apps/delta", nil, func(req *http.Request) {
+		req.Header.Set("Accept", e.Encoding.contentType())
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: respBytes}
+	}
+
+	apps, hashcode, err := e.decodeApplications(respBytes)
+	if err != nil {
+		return err
+	}
+
+	e.cacheMu.Lock()
+	if e.cache == nil {
+		e.cache = newRegistryCache()
+	}
+	cache := e.cache
+	e.cacheMu.Unlock()
+
+	return cache.applyDelta(apps, hashcode)
+}
+
+func (c *registryCache) replace(apps map[string][]Instance, hashcode string) {
+	c.mu.Lock()
+	previous := c.apps
+	c.apps = apps
+	c.hashcode = hashcode
+	c.mu.Unlock()
+
+	for name, instances := range apps {
+		c.notify(name, instances)
+	}
+	for name := range previous {
+		if _, ok := apps[name]; !ok {
+			c.notify(name, nil)
+		}
+	}
+}
+
+// applyDelta merges the ADDED/MODIFIED/DELETED entries returned by
+// /eureka/apps/delta into the cache and checks the result against
+// hashcode. If the hashcodes disagree the cache is left untouched and an
+// error is returned so the caller falls back to a full fetch.
+func (c *registryCache) applyDelta(deltaApps map[string][]Instance, hashcode string) error {
+	c.mu.Lock()
+
+	merged := make(map[string][]Instance, len(c.apps))
+	for name, instances := range c.apps {
+		merged[name] = append([]Instance(nil), instances...)
+	}
+
+	changed := map[string]bool{}
+	for name, instances := range deltaApps {
+		for _, instance := range instances {
+			switch instance.ActionType {
+			case "DELETED":
+				merged[name] = removeInstance(merged[name], instance)
+			default: // ADDED, MODIFIED
+				merged[name] = upsertInstance(merged[name], instance)
+			}
+			changed[name] = true
+		}
+	}
+
+	if computeAppsHashcode(merged) != hashcode {
+		c.mu.Unlock()
+		return fmt.Errorf("apps__hashcode mismatch after delta merge")
+	}
+
+	c.apps = merged
+	c.hashcode = hashcode
+	c.mu.Unlock()
+
+	for name := range changed {
+		c.notify(name, merged[name])
+	}
+	return nil
+}
+
+func (c *registryCache) notify(name string, instances []Instance) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	for _, ch := range c.watchers[name] {
+		snapshot := append([]Instance(nil), instances...)
+		select {
+		case ch <- snapshot:
+		default:
+			// Replace the unread snapshot rather than block the refresh
+			// loop on a slow watcher, so its next read sees the latest list.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- snapshot:
+			default:
+			}
+		}
+	}
+}
+
+// upsertInstance and removeInstance key off instanceKey rather than IPAddr
+// so that co-located instances distinguished only by port (see
+// ServiceInstance and instanceID() in heartbeat.go) don't collide in the
+// cache.
+func upsertInstance(instances []Instance, instance Instance) []Instance {
+	key := instanceKey(instance)
+	for i, existing := range instances {
+		if instanceKey(existing) == key {
+			instances[i] = instance
+			return instances
+		}
+	}
+	return append(instances, instance)
+}
+
+func removeInstance(instances []Instance, instance Instance) []Instance {
+	key := instanceKey(instance)
+	for i, existing := range instances {
+		if instanceKey(existing) == key {
+			return append(instances[:i], instances[i+1:]...)
+		}
+	}
+	return instances
+}
+
+// computeAppsHashcode reproduces Eureka's apps__hashcode format: the
+// per-status instance counts across the whole registry, concatenated in
+// ascending status order as "STATUS_count_".
+func computeAppsHashcode(apps map[string][]Instance) string {
+	counts := map[string]int{}
+	for _, instances := range apps {
+		for _, instance := range instances {
+			status := instance.Status
+			if status == "" {
+				status = "UP"
+			}
+			counts[status]++
+		}
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	hashcode := ""
+	for _, status := range statuses {
+		hashcode += fmt.Sprintf("%s_%d_", status, counts[status])
+	}
+	return hashcode
+}
@@ -0,0 +1,84 @@
+package lib
+
+import "testing"
+
+func coLocatedInstance(instanceID string, port float64, status string) Instance {
+	return Instance{
+		InstanceId: instanceID,
+		IPAddr:     "10.0.0.5",
+		App:        "API",
+		Status:     status,
+		Port:       map[string]interface{}{"$": port, "@enabled": "true"},
+	}
+}
+
+// TestApplyDeltaColocatedInstances guards against keying the cache by
+// IPAddr alone: two instances sharing a host but differing by port (the
+// configuration ServiceInstance/instanceID() is built to support) must be
+// tracked independently through both MODIFIED and DELETED deltas.
+func TestApplyDeltaColocatedInstances(t *testing.T) {
+	instance0 := coLocatedInstance("api-0-8080", 8080, StatusUp)
+	instance1 := coLocatedInstance("api-1-8081", 8081, StatusUp)
+
+	cache := newRegistryCache()
+	cache.replace(map[string][]Instance{"API": {instance0, instance1}}, computeAppsHashcode(map[string][]Instance{
+		"API": {instance0, instance1},
+	}))
+
+	t.Run("MODIFIED updates only the matching instance", func(t *testing.T) {
+		modified := coLocatedInstance("api-1-8081", 8081, StatusOutOfService)
+		modified.ActionType = "MODIFIED"
+
+		wantHashcode := computeAppsHashcode(map[string][]Instance{
+			"API": {instance0, modified},
+		})
+		if err := cache.applyDelta(map[string][]Instance{"API": {modified}}, wantHashcode); err != nil {
+			t.Fatalf("applyDelta: %s", err)
+		}
+
+		apps := cache.apps["API"]
+		if len(apps) != 2 {
+			t.Fatalf("got %d instances, want 2", len(apps))
+		}
+		for _, instance := range apps {
+			switch instance.InstanceId {
+			case "api-0-8080":
+				if instance.Status != StatusUp {
+					t.Errorf("api-0-8080 status = %q, want unchanged %q", instance.Status, StatusUp)
+				}
+			case "api-1-8081":
+				if instance.Status != StatusOutOfService {
+					t.Errorf("api-1-8081 status = %q, want %q", instance.Status, StatusOutOfService)
+				}
+			default:
+				t.Errorf("unexpected instance %q in cache", instance.InstanceId)
+			}
+		}
+	})
+
+	t.Run("DELETED removes only the matching instance", func(t *testing.T) {
+		deleted := coLocatedInstance("api-0-8080", 8080, StatusUp)
+		deleted.ActionType = "DELETED"
+
+		remaining := cache.apps["API"]
+		var kept []Instance
+		for _, instance := range remaining {
+			if instance.InstanceId != "api-0-8080" {
+				kept = append(kept, instance)
+			}
+		}
+		wantHashcode := computeAppsHashcode(map[string][]Instance{"API": kept})
+
+		if err := cache.applyDelta(map[string][]Instance{"API": {deleted}}, wantHashcode); err != nil {
+			t.Fatalf("applyDelta: %s", err)
+		}
+
+		apps := cache.apps["API"]
+		if len(apps) != 1 {
+			t.Fatalf("got %d instances, want 1", len(apps))
+		}
+		if apps[0].InstanceId != "api-1-8081" {
+			t.Errorf("remaining instance = %q, want %q", apps[0].InstanceId, "api-1-8081")
+		}
+	})
+}
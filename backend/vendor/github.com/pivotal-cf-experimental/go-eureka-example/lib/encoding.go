@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// Encoding selects which representation of the Eureka REST contract an
+// EurekaClient speaks. Some Eureka servers, and Spring Cloud gateways in
+// front of them, only reliably round-trip the XML form of InstanceInfo.
+type Encoding int
+
+const (
+	// EncodingJSON is the zero value, so a client with Encoding left unset
+	// keeps the historical JSON behavior.
+	EncodingJSON Encoding = iota
+	EncodingXML
+)
+
+func (enc Encoding) contentType() string {
+	if enc == EncodingXML {
+		return "application/xml"
+	}
+	return "application/json"
+}
+
+// registerInstance is the body Register sends to Eureka. Its json tags
+// produce the historical {"instance": {...}} shape; marshaled on its own
+// with xml tags it produces the XML contract's root <instance> element.
+type registerInstance struct {
+	XMLName        xml.Name       `json:"-" xml:"instance"`
+	HostName       string         `json:"hostName" xml:"hostName"`
+	InstanceId     string         `json:"instanceId" xml:"instanceId"`
+	App            string         `json:"app" xml:"app"`
+	IPAddr         string         `json:"ipAddr" xml:"ipAddr"`
+	Status         string         `json:"status" xml:"status"`
+	Port           instancePort   `json:"port" xml:"port"`
+	DataCenterInfo dataCenterInfo `json:"dataCenterInfo" xml:"dataCenterInfo"`
+}
+
+type registerRequest struct {
+	Instance registerInstance `json:"instance"`
+}
+
+type instancePort struct {
+	Value   string `json:"$" xml:",chardata"`
+	Enabled string `json:"@enabled" xml:"enabled,attr"`
+}
+
+type dataCenterInfo struct {
+	Class string `json:"@class" xml:"class,attr"`
+	Name  string `json:"name" xml:"name"`
+}
+
+// marshalRegisterInstance encodes ri for Register according to e's
+// configured Encoding.
+func (e *EurekaClient) marshalRegisterInstance(ri registerInstance) ([]byte, error) {
+	if e.Encoding == EncodingXML {
+		return xml.Marshal(ri)
+	}
+	return json.Marshal(registerRequest{Instance: ri})
+}
+
+// xmlApplications mirrors the root <applications> element returned by
+// /eureka/apps: the JSON equivalent nests the same fields one level
+// deeper, under an "applications" key, which is why this isn't shared with
+// applicationsResp in cache.go.
+type xmlApplications struct {
+	XMLName      xml.Name      `xml:"applications"`
+	AppsHashcode string        `xml:"apps__hashcode"`
+	Application  []Application `xml:"application"`
+}
+
+// decodeApplication decodes a single-application response from either
+// /eureka/apps/{app} (JSON, wrapped in EurekaRegistryResp) or its XML
+// equivalent (an unwrapped <application> root).
+func (e *EurekaClient) decodeApplication(respBytes []byte) (Application, error) {
+	if e.Encoding == EncodingXML {
+		var application Application
+		err := xml.Unmarshal(respBytes, &application)
+		return application, err
+	}
+
+	var respStruct EurekaRegistryResp
+	if err := json.Unmarshal(respBytes, &respStruct); err != nil {
+		return Application{}, err
+	}
+	return respStruct.Application, nil
+}
+
+// decodeApplications decodes a full registry response from either
+// /eureka/apps or /eureka/apps/delta (JSON or XML), returning the
+// per-application instance lists and the apps__hashcode used to validate
+// delta merges.
+func (e *EurekaClient) decodeApplications(respBytes []byte) (map[string][]Instance, string, error) {
+	var applications []Application
+	var hashcode string
+
+	if e.Encoding == EncodingXML {
+		var respStruct xmlApplications
+		if err := xml.Unmarshal(respBytes, &respStruct); err != nil {
+			return nil, "", err
+		}
+		applications = respStruct.Application
+		hashcode = respStruct.AppsHashcode
+	} else {
+		var respStruct applicationsResp
+		if err := json.Unmarshal(respBytes, &respStruct); err != nil {
+			return nil, "", err
+		}
+		applications = respStruct.Applications.Application
+		hashcode = respStruct.Applications.AppsHashcode
+	}
+
+	apps := make(map[string][]Instance, len(applications))
+	for _, app := range applications {
+		apps[app.Name] = app.Instances
+	}
+	return apps, hashcode, nil
+}
+
+// UnmarshalXML decodes an <instance> element into Instance, storing its
+// <port enabled="..."> element in the same "$"/"@enabled" map shape the
+// JSON encoding uses so callers can read Port the same way regardless of
+// which wire format the instance came from.
+func (i *Instance) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		InstanceId string `xml:"instanceId"`
+		IPAddr     string `xml:"ipAddr"`
+		App        string `xml:"app"`
+		Status     string `xml:"status"`
+		ActionType string `xml:"actionType"`
+		Port       struct {
+			Value   string `xml:",chardata"`
+			Enabled string `xml:"enabled,attr"`
+		} `xml:"port"`
+	}
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	i.InstanceId = raw.InstanceId
+	i.IPAddr = raw.IPAddr
+	i.App = raw.App
+	i.Status = raw.Status
+	i.ActionType = raw.ActionType
+	i.Port = map[string]interface{}{
+		"$":        raw.Port.Value,
+		"@enabled": raw.Port.Enabled,
+	}
+	return nil
+}
+
+// portNumber reads Instance.Port["$"], which is a JSON number when decoded
+// from Eureka's JSON contract but a string when decoded from XML.
+func portNumber(instance Instance) (int, error) {
+	switch v := instance.Port["$"].(type) {
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("instance %s has no usable port", instance.IPAddr)
+	}
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// TestMarshalRegisterInstanceXML verifies that registerInstance round-trips
+// through the XML encoding: the fields Register depends on (including
+// InstanceId, which upsertInstance/removeInstance key cache merges on)
+// survive a marshal/unmarshal cycle.
+func TestMarshalRegisterInstanceXML(t *testing.T) {
+	e := &EurekaClient{Encoding: EncodingXML}
+	ri := registerInstance{
+		HostName:   "api-0-8080",
+		InstanceId: "api-0-8080",
+		App:        "api",
+		IPAddr:     "10.0.0.1",
+		Status:     StatusUp,
+		Port:       instancePort{Value: "8080", Enabled: "true"},
+		DataCenterInfo: dataCenterInfo{
+			Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+			Name:  "MyOwn",
+		},
+	}
+
+	body, err := e.marshalRegisterInstance(ri)
+	if err != nil {
+		t.Fatalf("marshalRegisterInstance: %s", err)
+	}
+
+	var decoded registerInstance
+	if err := xml.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("xml.Unmarshal: %s", err)
+	}
+
+	if decoded.InstanceId != ri.InstanceId {
+		t.Errorf("InstanceId = %q, want %q", decoded.InstanceId, ri.InstanceId)
+	}
+	if decoded.HostName != ri.HostName {
+		t.Errorf("HostName = %q, want %q", decoded.HostName, ri.HostName)
+	}
+	if decoded.IPAddr != ri.IPAddr {
+		t.Errorf("IPAddr = %q, want %q", decoded.IPAddr, ri.IPAddr)
+	}
+	if decoded.Port.Value != ri.Port.Value {
+		t.Errorf("Port.Value = %q, want %q", decoded.Port.Value, ri.Port.Value)
+	}
+}
+
+// TestDecodeApplicationXML verifies Instance.UnmarshalXML against a
+// single-application response, including the instanceId and port fields
+// that the JSON decode path gets for free via struct tags.
+func TestDecodeApplicationXML(t *testing.T) {
+	e := &EurekaClient{Encoding: EncodingXML}
+	body := []byte(`<application>
+		<name>api</name>
+		<instance>
+			<instanceId>api-0-8080</instanceId>
+			<ipAddr>10.0.0.1</ipAddr>
+			<app>api</app>
+			<status>UP</status>
+			<port enabled="true">8080</port>
+		</instance>
+		<instance>
+			<instanceId>api-1-8081</instanceId>
+			<ipAddr>10.0.0.1</ipAddr>
+			<app>api</app>
+			<status>UP</status>
+			<port enabled="true">8081</port>
+		</instance>
+	</application>`)
+
+	application, err := e.decodeApplication(body)
+	if err != nil {
+		t.Fatalf("decodeApplication: %s", err)
+	}
+
+	if len(application.Instances) != 2 {
+		t.Fatalf("got %d instances, want 2", len(application.Instances))
+	}
+
+	first := application.Instances[0]
+	if first.InstanceId != "api-0-8080" {
+		t.Errorf("Instances[0].InstanceId = %q, want api-0-8080", first.InstanceId)
+	}
+	port, err := portNumber(first)
+	if err != nil {
+		t.Fatalf("portNumber: %s", err)
+	}
+	if port != 8080 {
+		t.Errorf("Instances[0] port = %d, want 8080", port)
+	}
+
+	second := application.Instances[1]
+	if second.InstanceId != "api-1-8081" {
+		t.Errorf("Instances[1].InstanceId = %q, want api-1-8081", second.InstanceId)
+	}
+}
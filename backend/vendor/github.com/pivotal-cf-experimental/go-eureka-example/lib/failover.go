@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// orderedServers returns this client's Eureka servers in the shuffled,
+// zone-preferred order it will try them in. The order is computed once
+// per client and reused for the client's lifetime so that, all else being
+// equal, repeated calls keep hitting the same server instead of
+// re-randomizing on every request.
+func (e *EurekaClient) orderedServers() []string {
+	e.serverOrderOnce.Do(func() {
+		e.serverOrder = shuffleWithZonePreference(e.BaseURLs, e.Zone, e.ZoneURLs)
+	})
+	return e.serverOrder
+}
+
+func shuffleWithZonePreference(baseURLs []string, zone string, zoneURLs map[string]string) []string {
+	var preferred, rest []string
+	for _, u := range baseURLs {
+		if zone != "" && zoneURLs[u] == zone {
+			preferred = append(preferred, u)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	rand.Shuffle(len(preferred), func(i, j int) { preferred[i], preferred[j] = preferred[j], preferred[i] })
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	return append(preferred, rest...)
+}
+
+func (e *EurekaClient) isServerQuarantined(baseURL string) bool {
+	e.serverMu.Lock()
+	defer e.serverMu.Unlock()
+	return e.quarantinedServers[baseURL]
+}
+
+// quarantineServer removes baseURL from consideration until every known
+// server has been quarantined, at which point the whole set is cleared so
+// the next call gets a fresh shot at all of them rather than failing
+// outright.
+func (e *EurekaClient) quarantineServer(baseURL string) {
+	e.serverMu.Lock()
+	defer e.serverMu.Unlock()
+
+	if e.quarantinedServers == nil {
+		e.quarantinedServers = map[string]bool{}
+	}
+	e.quarantinedServers[baseURL] = true
+
+	if len(e.quarantinedServers) >= len(e.BaseURLs) {
+		e.quarantinedServers = map[string]bool{}
+	}
+}
+
+// do sends a request built from method, route, and body against each of
+// this client's Eureka servers in turn, starting from orderedServers(),
+// trying any that are currently quarantined only after all the others. A
+// connection error or 5xx
+// response quarantines that server and moves on to the next; any other
+// response (including 4xx, which is a client error rather than a server
+// availability problem) is returned immediately. setHeaders is called on
+// each attempt's request so headers like Authorization can be set once
+// and replayed across retries.
+func (e *EurekaClient) do(method, route string, body []byte, setHeaders func(*http.Request)) (*http.Response, []byte, error) {
+	servers := e.orderedServers()
+	if len(servers) == 0 {
+		return nil, nil, fmt.Errorf("no eureka servers configured")
+	}
+
+	var lastErr error
+	for _, attempt := range append(liveServers(servers, e), quarantinedServers(servers, e)...) {
+		reqURL, err := joinURL(attempt, route)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var bodyReader *bytes.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		} else {
+			bodyReader = bytes.NewReader(nil)
+		}
+
+		req, err := http.NewRequest(method, reqURL, bodyReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		setHeaders(req)
+
+		resp, err := e.HttpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			e.quarantineServer(attempt)
+			continue
+		}
+
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			e.quarantineServer(attempt)
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = &statusError{code: resp.StatusCode, body: respBytes}
+			e.quarantineServer(attempt)
+			continue
+		}
+
+		return resp, respBytes, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no eureka servers available")
+	}
+	return nil, nil, lastErr
+}
+
+// liveServers and quarantinedServers partition servers into those
+// currently usable and those currently quarantined, preserving order
+// within each group. Quarantined servers are appended as a last resort so
+// a call still succeeds if every server happens to be quarantined.
+func liveServers(servers []string, e *EurekaClient) []string {
+	live := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if !e.isServerQuarantined(s) {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
+func quarantinedServers(servers []string, e *EurekaClient) []string {
+	quarantined := make([]string, 0)
+	for _, s := range servers {
+		if e.isServerQuarantined(s) {
+			quarantined = append(quarantined, s)
+		}
+	}
+	return quarantined
+}
+
+// joinURL joins baseURL with route, which may itself carry a query string
+// (as UpdateStatus's route does). The query is parsed out and assigned to
+// RawQuery rather than joined into the path: path.Join-ing it in would
+// leave the "?" percent-encoded as part of the path, so it would never
+// reach the server as an actual query parameter.
+func joinURL(baseURL, route string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse base url: %s", err)
+	}
+	routeURL, err := url.Parse(route)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse route: %s", err)
+	}
+	u.Path = path.Join(u.Path, routeURL.Path)
+	u.RawQuery = routeURL.RawQuery
+	return u.String(), nil
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestJoinURLPreservesQuery guards against the query string of a route
+// such as UpdateStatus's "/.../status?value=UP" getting percent-encoded
+// into the path instead of reaching the server as an actual query
+// parameter.
+func TestJoinURLPreservesQuery(t *testing.T) {
+	got, err := joinURL("http://eureka.example.com", "/eureka/apps/api/api-0-8080/status?value=UP")
+	if err != nil {
+		t.Fatalf("joinURL: %s", err)
+	}
+
+	const want = "http://eureka.example.com/eureka/apps/api/api-0-8080/status?value=UP"
+	if got != want {
+		t.Errorf("joinURL = %q, want %q", got, want)
+	}
+}
+
+// TestDoFailsOverAndQuarantines verifies that do() moves on to the next
+// server on a 5xx response and quarantines the one that failed, so a
+// subsequent call goes straight to the live server.
+func TestDoFailsOverAndQuarantines(t *testing.T) {
+	var badCount, goodCount int
+	var mu sync.Mutex
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		badCount++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(bad.Close)
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		goodCount++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(good.Close)
+
+	e := &EurekaClient{
+		BaseURLs:   []string{bad.URL, good.URL},
+		HttpClient: http.DefaultClient,
+	}
+	// Force a deterministic server order instead of relying on the random
+	// shuffle in orderedServers, so the first attempt always hits bad.URL.
+	e.serverOrderOnce.Do(func() {})
+	e.serverOrder = []string{bad.URL, good.URL}
+
+	resp, _, err := e.do("GET", "/eureka/apps/api", nil, func(*http.Request) {})
+	if err != nil {
+		t.Fatalf("do: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	if !e.isServerQuarantined(bad.URL) {
+		t.Errorf("bad server not quarantined after a 500")
+	}
+
+	mu.Lock()
+	if badCount != 1 {
+		t.Errorf("bad server received %d requests, want 1", badCount)
+	}
+	if goodCount != 1 {
+		t.Errorf("good server received %d requests, want 1", goodCount)
+	}
+	mu.Unlock()
+
+	// A second call should skip the quarantined server entirely.
+	if _, _, err := e.do("GET", "/eureka/apps/api", nil, func(*http.Request) {}); err != nil {
+		t.Fatalf("second do: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if badCount != 1 {
+		t.Errorf("bad server received %d requests after second call, want still 1 (quarantined)", badCount)
+	}
+	if goodCount != 2 {
+		t.Errorf("good server received %d requests after second call, want 2", goodCount)
+	}
+}
+
+// TestQuarantineServerResetsWhenAllQuarantined verifies that once every
+// known server is quarantined, the set clears so the next call gets a
+// fresh shot at all of them.
+func TestQuarantineServerResetsWhenAllQuarantined(t *testing.T) {
+	e := &EurekaClient{BaseURLs: []string{"http://a", "http://b"}}
+
+	e.quarantineServer("http://a")
+	if !e.isServerQuarantined("http://a") {
+		t.Fatalf("http://a not quarantined")
+	}
+
+	e.quarantineServer("http://b")
+	if e.isServerQuarantined("http://a") || e.isServerQuarantined("http://b") {
+		t.Errorf("quarantine set should have reset once every server was quarantined")
+	}
+}
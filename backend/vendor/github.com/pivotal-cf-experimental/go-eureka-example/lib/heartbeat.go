@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRenewInterval matches the 30 second lease renewal interval
+	// that the Eureka server expects from a well-behaved client.
+	DefaultRenewInterval = 30 * time.Second
+
+	renewRetries = 3
+)
+
+const (
+	StatusUp           = "UP"
+	StatusDown         = "DOWN"
+	StatusOutOfService = "OUT_OF_SERVICE"
+)
+
+// Start registers every configured ServiceInstance and begins renewing
+// their leases every RenewInterval until the context is cancelled or Stop
+// is called. Start returns once the initial registration has completed;
+// the heartbeat loop runs in the background.
+func (e *EurekaClient) Start(ctx context.Context) error {
+	if err := e.RegisterAll(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	if e.stopCh != nil {
+		e.mu.Unlock()
+		return fmt.Errorf("eureka client already started")
+	}
+	e.stopCh = make(chan struct{})
+	stopCh := e.stopCh
+	e.mu.Unlock()
+
+	interval := e.RenewInterval
+	if interval == 0 {
+		interval = DefaultRenewInterval
+	}
+
+	for _, s := range e.ServiceInstances {
+		e.wg.Add(1)
+		go e.heartbeat(ctx, s, interval, stopCh)
+	}
+
+	return nil
+}
+
+// Stop halts the heartbeat goroutines started by Start and deregisters
+// every instance so the Eureka server can evict them immediately instead
+// of waiting out their lease.
+func (e *EurekaClient) Stop() error {
+	e.mu.Lock()
+	stopCh := e.stopCh
+	e.stopCh = nil
+	e.mu.Unlock()
+
+	if stopCh == nil {
+		return nil
+	}
+	close(stopCh)
+	e.wg.Wait()
+
+	return e.DeregisterAll()
+}
+
+func (e *EurekaClient) heartbeat(ctx context.Context, s ServiceInstance, interval time.Duration, stopCh chan struct{}) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.renewWithRetry(s)
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *EurekaClient) renewWithRetry(s ServiceInstance) {
+	var err error
+	for attempt := 0; attempt < renewRetries; attempt++ {
+		err = e.Renew(s)
+		if err == nil {
+			return
+		}
+		if IsNotFoundError(err) {
+			// The server evicted this instance, most likely because a
+			// prior renew was missed for long enough that its lease
+			// expired. Re-registering recreates it.
+			if err := e.Register(s); err != nil {
+				log.Printf("eureka: re-register %s after 404 on renew: %s", instanceID(s), err)
+			}
+			return
+		}
+	}
+}
+
+// RenewAll sends a renew (heartbeat) request for every registered
+// ServiceInstance. Callers that want the managed background loop should
+// use Start instead.
+func (e *EurekaClient) RenewAll() error {
+	for _, s := range e.ServiceInstances {
+		if err := e.Renew(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renew sends a single PUT /eureka/apps/{app}/{instanceId} heartbeat for
+// serviceInstance, refreshing its lease on the Eureka server.
+func (e *EurekaClient) Renew(serviceInstance ServiceInstance) error {
+	token, err := e.UAAClient.GetToken()
+	if err != nil {
+		return err
+	}
+
+	route := fmt.Sprintf("/eureka/apps/%s/%s", serviceInstance.Name, instanceID(serviceInstance))
+	resp, respBytes, err := e.do("PUT", route, nil, func(req *http.Request) {
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: respBytes}
+	}
+
+	return nil
+}
+
+// DeregisterAll removes every registered ServiceInstance from the Eureka
+// server, typically called as part of a graceful shutdown.
+func (e *EurekaClient) DeregisterAll() error {
+	for _, s := range e.ServiceInstances {
+		if err := e.Deregister(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deregister sends a DELETE /eureka/apps/{app}/{instanceId} request,
+// removing serviceInstance from the Eureka server immediately rather than
+// waiting for its lease to expire.
+func (e *EurekaClient) Deregister(serviceInstance ServiceInstance) error {
+	token, err := e.UAAClient.GetToken()
+	if err != nil {
+		return err
+	}
+
+	route := fmt.Sprintf("/eureka/apps/%s/%s", serviceInstance.Name, instanceID(serviceInstance))
+	resp, respBytes, err := e.do("DELETE", route, nil, func(req *http.Request) {
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: respBytes}
+	}
+
+	return nil
+}
+
+// UpdateStatus sends a PUT /eureka/apps/{app}/{instanceId}/status?value=
+// request, moving serviceInstance into status (one of StatusUp,
+// StatusDown, or StatusOutOfService) without affecting its lease.
+func (e *EurekaClient) UpdateStatus(serviceInstance ServiceInstance, status string) error {
+	token, err := e.UAAClient.GetToken()
+	if err != nil {
+		return err
+	}
+
+	route := fmt.Sprintf("/eureka/apps/%s/%s/status?value=%s", serviceInstance.Name, instanceID(serviceInstance), status)
+	resp, respBytes, err := e.do("PUT", route, nil, func(req *http.Request) {
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: respBytes}
+	}
+
+	return nil
+}
+
+// instanceID returns the hostName Register assigns a ServiceInstance,
+// which Eureka also uses as its instanceId in the REST API.
+func instanceID(s ServiceInstance) string {
+	return fmt.Sprintf("%s-%d-%d", s.Name, s.Instance, s.Port)
+}
+
+// statusError wraps an unexpected Eureka REST response so callers such as
+// renewWithRetry can tell a 404 (instance evicted) apart from other
+// failures without parsing fmt.Errorf text.
+type statusError struct {
+	code int
+	body []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response code: %d: %s", e.code, e.body)
+}
+
+// IsNotFoundError reports whether err represents a 404 response from the
+// Eureka server, which typically means the instance's lease already
+// expired and it was evicted.
+func IsNotFoundError(err error) bool {
+	se, ok := err.(*statusError)
+	return ok && se.code == http.StatusNotFound
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testUAAServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testClient(uaaURL, eurekaURL string) *EurekaClient {
+	return &EurekaClient{
+		BaseURLs:   []string{eurekaURL},
+		HttpClient: http.DefaultClient,
+		UAAClient:  &UAAClient{BaseURL: uaaURL, HttpClient: http.DefaultClient},
+		ServiceInstances: []ServiceInstance{
+			{Name: "api", Instance: 0, IP: "10.0.0.1", Port: 8080},
+		},
+	}
+}
+
+// TestRenewWithRetryReregisters verifies that a 404 on renew (the lease
+// having already expired server-side) triggers a re-register rather than
+// exhausting renewRetries against a route the server has already forgotten.
+func TestRenewWithRetryReregisters(t *testing.T) {
+	uaa := testUAAServer(t)
+
+	var mu sync.Mutex
+	var registerCount, renewCount int
+	eureka := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "PUT":
+			renewCount++
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "POST":
+			registerCount++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(eureka.Close)
+
+	e := testClient(uaa.URL, eureka.URL)
+	e.renewWithRetry(e.ServiceInstances[0])
+
+	mu.Lock()
+	defer mu.Unlock()
+	if renewCount != 1 {
+		t.Errorf("renew attempts = %d, want 1 (should re-register on first 404 rather than retrying the renew)", renewCount)
+	}
+	if registerCount != 1 {
+		t.Errorf("register attempts = %d, want 1", registerCount)
+	}
+}
+
+// TestStartStopLifecycle exercises Start's initial registration and
+// background renew loop, and Stop's deregistration on shutdown.
+func TestStartStopLifecycle(t *testing.T) {
+	uaa := testUAAServer(t)
+
+	var mu sync.Mutex
+	var registerCount, renewCount, deregisterCount int
+	eureka := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "POST":
+			registerCount++
+			w.WriteHeader(http.StatusNoContent)
+		case "PUT":
+			renewCount++
+			w.WriteHeader(http.StatusOK)
+		case "DELETE":
+			deregisterCount++
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(eureka.Close)
+
+	e := testClient(uaa.URL, eureka.URL)
+	e.RenewInterval = 5 * time.Millisecond
+
+	ctx := context.Background()
+	if err := e.Start(ctx); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+
+	mu.Lock()
+	if registerCount != 1 {
+		mu.Unlock()
+		t.Fatalf("register attempts = %d, want 1 after Start", registerCount)
+	}
+	mu.Unlock()
+
+	time.Sleep(30 * time.Millisecond)
+
+	if err := e.Stop(); err != nil {
+		t.Fatalf("Stop: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if renewCount == 0 {
+		t.Errorf("renew attempts = 0, want at least 1 from the background heartbeat loop")
+	}
+	if deregisterCount != 1 {
+		t.Errorf("deregister attempts = %d, want 1 after Stop", deregisterCount)
+	}
+}
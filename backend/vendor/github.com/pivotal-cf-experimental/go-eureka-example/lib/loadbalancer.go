@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQuarantineThreshold is the number of consecutive reported
+	// failures that quarantines an instance.
+	DefaultQuarantineThreshold = 3
+
+	// DefaultQuarantineCooldown is how long an instance stays quarantined
+	// before it's eligible for selection again.
+	DefaultQuarantineCooldown = 30 * time.Second
+)
+
+// LoadBalancer picks one instance to use out of the candidates
+// GetAppByName fetched from Eureka. Implementations must be safe for
+// concurrent use.
+type LoadBalancer interface {
+	Choose(candidates []Instance) (Instance, error)
+}
+
+// LatencyRecorder is implemented by LoadBalancers that want to factor
+// observed round-trip times into future selections. ReportSuccess passes
+// the observed latency through to it when the configured LoadBalancer
+// supports it.
+type LatencyRecorder interface {
+	RecordLatency(address string, rtt time.Duration)
+}
+
+// RandomLoadBalancer picks a candidate uniformly at random. This is the
+// historical GetAppByName behavior.
+type RandomLoadBalancer struct{}
+
+func (*RandomLoadBalancer) Choose(candidates []Instance) (Instance, error) {
+	if len(candidates) == 0 {
+		return Instance{}, fmt.Errorf("no candidate instances to choose from")
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// RoundRobinLoadBalancer cycles through candidates in order.
+type RoundRobinLoadBalancer struct {
+	counter uint64
+}
+
+func (r *RoundRobinLoadBalancer) Choose(candidates []Instance) (Instance, error) {
+	if len(candidates) == 0 {
+		return Instance{}, fmt.Errorf("no candidate instances to choose from")
+	}
+	n := atomic.AddUint64(&r.counter, 1)
+	return candidates[int(n-1)%len(candidates)], nil
+}
+
+// LatencyWeightedLoadBalancer biases selection toward instances that have
+// historically responded faster, based on RTTs reported through
+// RecordLatency. Instances with no recorded latency yet are always
+// preferred, so every candidate gets probed at least once.
+type LatencyWeightedLoadBalancer struct {
+	mu   sync.Mutex
+	rtts map[string]time.Duration
+}
+
+func (l *LatencyWeightedLoadBalancer) Choose(candidates []Instance) (Instance, error) {
+	if len(candidates) == 0 {
+		return Instance{}, fmt.Errorf("no candidate instances to choose from")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	best := candidates[0]
+	bestRTT, bestKnown := l.rtts[instanceAddress(best)]
+	for _, candidate := range candidates[1:] {
+		rtt, known := l.rtts[instanceAddress(candidate)]
+		switch {
+		case !known && bestKnown:
+			best, bestRTT, bestKnown = candidate, rtt, known
+		case known && bestKnown && rtt < bestRTT:
+			best, bestRTT, bestKnown = candidate, rtt, known
+		}
+	}
+	return best, nil
+}
+
+func (l *LatencyWeightedLoadBalancer) RecordLatency(address string, rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rtts == nil {
+		l.rtts = map[string]time.Duration{}
+	}
+	l.rtts[address] = rtt
+}
+
+// quarantine tracks consecutive per-instance failures reported through
+// EurekaClient.ReportFailure, removing an instance from selection once it
+// crosses the configured threshold until its cooldown elapses.
+type quarantine struct {
+	mu          sync.Mutex
+	failures    map[string]int
+	bannedUntil map[string]time.Time
+}
+
+func newQuarantine() *quarantine {
+	return &quarantine{
+		failures:    map[string]int{},
+		bannedUntil: map[string]time.Time{},
+	}
+}
+
+func (q *quarantine) filter(instances []Instance) []Instance {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	candidates := make([]Instance, 0, len(instances))
+	for _, instance := range instances {
+		key := instanceAddress(instance)
+		if until, banned := q.bannedUntil[key]; banned {
+			if now.Before(until) {
+				continue
+			}
+			delete(q.bannedUntil, key)
+			delete(q.failures, key)
+		}
+		candidates = append(candidates, instance)
+	}
+	return candidates
+}
+
+func (q *quarantine) recordFailure(address string, threshold int, cooldown time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.failures[address]++
+	if q.failures[address] >= threshold {
+		q.bannedUntil[address] = time.Now().Add(cooldown)
+	}
+}
+
+func (q *quarantine) recordSuccess(address string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.failures, address)
+}
+
+func (e *EurekaClient) loadBalancer() LoadBalancer {
+	if e.LoadBalancer == nil {
+		return &RandomLoadBalancer{}
+	}
+	return e.LoadBalancer
+}
+
+func (e *EurekaClient) getQuarantine() *quarantine {
+	e.lbMu.Lock()
+	defer e.lbMu.Unlock()
+	if e.quarantine == nil {
+		e.quarantine = newQuarantine()
+	}
+	return e.quarantine
+}
+
+// ReportSuccess tells the client that a call to address (as returned by
+// GetAppByName) succeeded after taking rtt, clearing any accumulated
+// failures for it and, if the configured LoadBalancer is a
+// LatencyRecorder, feeding it the observed latency. address is used
+// as-is, including its port, so that co-located instances distinguished
+// only by port aren't conflated (see instanceAddress).
+func (e *EurekaClient) ReportSuccess(address string, rtt time.Duration) {
+	e.getQuarantine().recordSuccess(address)
+	if recorder, ok := e.loadBalancer().(LatencyRecorder); ok {
+		recorder.RecordLatency(address, rtt)
+	}
+}
+
+// ReportFailure tells the client that a call to address (as returned by
+// GetAppByName) failed, quarantining the instance once it has failed
+// QuarantineThreshold times in a row.
+func (e *EurekaClient) ReportFailure(address string) {
+	threshold := e.QuarantineThreshold
+	if threshold == 0 {
+		threshold = DefaultQuarantineThreshold
+	}
+	cooldown := e.QuarantineCooldown
+	if cooldown == 0 {
+		cooldown = DefaultQuarantineCooldown
+	}
+	e.getQuarantine().recordFailure(address, threshold, cooldown)
+}
+
+// instanceAddress returns the "ip:port" address GetAppByName would return
+// for instance, so the quarantine and latency maps can be keyed the same
+// way whether they're populated from a ReportSuccess/ReportFailure address
+// or from a freshly fetched Instance. It falls back to the bare IP if the
+// instance has no usable port.
+func instanceAddress(instance Instance) string {
+	port, err := portNumber(instance)
+	if err != nil {
+		return instance.IPAddr
+	}
+	return fmt.Sprintf("%s:%d", instance.IPAddr, port)
+}
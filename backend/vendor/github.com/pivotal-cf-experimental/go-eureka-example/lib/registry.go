@@ -1,22 +1,68 @@
 package lib
 
 import (
-	"bytes"
-	"encoding/json"
+	"encoding/xml"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
-	"net/url"
-	"path"
-	"strings"
+	"sync"
+	"time"
 )
 
 type EurekaClient struct {
-	BaseURL          string
+	// BaseURLs lists every known Eureka server. Requests are tried against
+	// them in a shuffled, per-client order, failing over to the next
+	// server on a connection error or 5xx response.
+	BaseURLs         []string
 	HttpClient       *http.Client
 	UAAClient        *UAAClient
 	ServiceInstances []ServiceInstance
+
+	// Zone, if set, is this client's availability zone. Servers whose
+	// BaseURL is tagged with the same zone in ZoneURLs are tried before
+	// any others.
+	Zone     string
+	ZoneURLs map[string]string
+
+	// RenewInterval controls how often Start renews each instance's
+	// lease. Defaults to DefaultRenewInterval when unset.
+	RenewInterval time.Duration
+
+	// CacheRefreshInterval controls how often StartCache refreshes the
+	// local registry cache. Defaults to DefaultCacheRefreshInterval when
+	// unset.
+	CacheRefreshInterval time.Duration
+
+	// LoadBalancer selects which instance GetAppByName returns out of the
+	// candidates Eureka reports for an app. Defaults to a
+	// RandomLoadBalancer when unset.
+	LoadBalancer LoadBalancer
+
+	// QuarantineThreshold and QuarantineCooldown configure when
+	// ReportFailure removes an instance from GetAppByName selection and
+	// how long it stays removed. Both default when unset.
+	QuarantineThreshold int
+	QuarantineCooldown  time.Duration
+
+	// Encoding selects which representation of the Eureka REST contract
+	// this client speaks. Defaults to EncodingJSON when unset.
+	Encoding Encoding
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	cache       *registryCache
+	cacheMu     sync.Mutex
+	cacheStopCh chan struct{}
+	cacheWg     sync.WaitGroup
+
+	lbMu       sync.Mutex
+	quarantine *quarantine
+
+	serverOrderOnce    sync.Once
+	serverOrder        []string
+	serverMu           sync.Mutex
+	quarantinedServers map[string]bool
 }
 
 func (e *EurekaClient) RegisterAll() error {
@@ -35,52 +81,36 @@ func (e *EurekaClient) Register(serviceInstance ServiceInstance) error {
 		return err
 	}
 
-	postBody := map[string]interface{}{
-		"instance": map[string]interface{}{
-			"hostName": fmt.Sprintf("%s-%d-%d", serviceInstance.Name, serviceInstance.Instance, serviceInstance.Port),
-			"app":      serviceInstance.Name,
-			"ipAddr":   serviceInstance.IP,
-			"status":   "UP",
-			"port": map[string]interface{}{
-				"$":        fmt.Sprintf("%d", serviceInstance.Port),
-				"@enabled": "true",
-			},
-			"dataCenterInfo": map[string]interface{}{
-				"@class": "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
-				"name":   "MyOwn",
-			},
+	ri := registerInstance{
+		HostName:   instanceID(serviceInstance),
+		InstanceId: instanceID(serviceInstance),
+		App:        serviceInstance.Name,
+		IPAddr:     serviceInstance.IP,
+		Status:     StatusUp,
+		Port: instancePort{
+			Value:   fmt.Sprintf("%d", serviceInstance.Port),
+			Enabled: "true",
+		},
+		DataCenterInfo: dataCenterInfo{
+			Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+			Name:  "MyOwn",
 		},
 	}
-	reqBytes, err := json.Marshal(postBody)
-	if err != nil {
-		return err
-	}
-
-	url, err := e.createURL(fmt.Sprintf("/eureka/apps/%s", serviceInstance.Name))
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBytes))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
-
-	resp, err := e.HttpClient.Do(req)
+	reqBytes, err := e.marshalRegisterInstance(ri)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	respBytes, err := ioutil.ReadAll(resp.Body)
+	resp, respBytes, err := e.do("POST", fmt.Sprintf("/eureka/apps/%s", serviceInstance.Name), reqBytes, func(req *http.Request) {
+		req.Header.Set("Content-Type", e.Encoding.contentType())
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
 	if err != nil {
 		return err
 	}
 
 	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected response code: %d: %s", resp.StatusCode, respBytes)
+		return &statusError{code: resp.StatusCode, body: respBytes}
 	}
 
 	return nil
@@ -91,67 +121,87 @@ type EurekaRegistryResp struct {
 }
 
 type Application struct {
-	Instances []Instance `json:"instance"`
+	XMLName   xml.Name   `json:"-" xml:"application"`
+	Name      string     `json:"name" xml:"name"`
+	Instances []Instance `json:"instance" xml:"instance"`
 }
 
+// Instance's Port is a map rather than a typed value because Eureka's JSON
+// contract represents it as {"$": <port>, "@enabled": <bool>} regardless
+// of encoding; see Instance.UnmarshalXML in encoding.go for how the XML
+// contract's <port enabled="..."> element is mapped onto the same shape.
 type Instance struct {
-	IPAddr string                 `json:"ipAddr"`
-	App    string                 `json:"app"`
-	Port   map[string]interface{} `json:"port"`
+	// InstanceId is Eureka's unique identifier for this instance, matching
+	// the hostName Register assigns via instanceID(). Unlike IPAddr, it
+	// distinguishes instances that share a host but differ by port.
+	InstanceId string                 `json:"instanceId"`
+	IPAddr     string                 `json:"ipAddr"`
+	App        string                 `json:"app"`
+	Port       map[string]interface{} `json:"port"`
+	Status     string                 `json:"status"`
+
+	// ActionType is only populated on entries returned from the
+	// /eureka/apps/delta endpoint, where it is one of ADDED, MODIFIED, or
+	// DELETED.
+	ActionType string `json:"actionType,omitempty"`
 }
 
-func (e *EurekaClient) GetAppByName(appName string) (string, error) {
-	token, err := e.UAAClient.GetToken()
-	if err != nil {
-		return "", err
+// instanceKey returns the identifier that uniquely distinguishes instance
+// from every other instance of the same app, for use as a registry cache
+// key (see upsertInstance/removeInstance in cache.go). It falls back to
+// ipAddr:port for instances whose wire payload left InstanceId unset,
+// since IPAddr alone collides for co-located instances distinguished only
+// by port.
+func instanceKey(instance Instance) string {
+	if instance.InstanceId != "" {
+		return instance.InstanceId
+	}
+	if port, err := portNumber(instance); err == nil {
+		return fmt.Sprintf("%s:%d", instance.IPAddr, port)
 	}
+	return instance.IPAddr
+}
 
-	url, err := e.createURL(fmt.Sprintf("/eureka/apps/%s", appName))
+func (e *EurekaClient) GetAppByName(appName string) (string, error) {
+	token, err := e.UAAClient.GetToken()
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	resp, respBytes, err := e.do("GET", fmt.Sprintf("/eureka/apps/%s", appName), nil, func(req *http.Request) {
+		req.Header.Set("Accept", e.Encoding.contentType())
+		req.Header.Set("Authorization", token.authorizationHeader())
+	})
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
 
-	resp, err := e.HttpClient.Do(req)
-	if err != nil {
-		return "", err
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{code: resp.StatusCode, body: respBytes}
 	}
-	defer resp.Body.Close()
 
-	respBytes, err := ioutil.ReadAll(resp.Body)
+	application, err := e.decodeApplication(respBytes)
 	if err != nil {
 		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected response code: %d: %s", resp.StatusCode, respBytes)
+	candidates := e.getQuarantine().filter(application.Instances)
+	if len(candidates) == 0 {
+		// Everything is quarantined; fall back to the full instance list
+		// rather than refusing to serve a lookup at all.
+		candidates = application.Instances
 	}
 
-	var respStruct EurekaRegistryResp
-	err = json.Unmarshal(respBytes, &respStruct)
+	instance, err := e.loadBalancer().Choose(candidates)
 	if err != nil {
 		return "", err
 	}
 
-	instanceIndex := rand.Intn(len(respStruct.Application.Instances))
-	serviceIP := respStruct.Application.Instances[instanceIndex].IPAddr
-	servicePort := respStruct.Application.Instances[instanceIndex].Port["$"].(float64)
-	return fmt.Sprintf("%s:%d", serviceIP, int(servicePort)), nil
-}
-
-func (e *EurekaClient) createURL(route string) (string, error) {
-	u, err := url.Parse(e.BaseURL)
+	servicePort, err := portNumber(instance)
 	if err != nil {
-		return "", fmt.Errorf("unable to parse base url: %s", err)
+		return "", err
 	}
-	u.Path = path.Join(u.Path, route)
-	return u.String(), nil
+	return fmt.Sprintf("%s:%d", instance.IPAddr, servicePort), nil
 }
 
 type ServiceInstance struct {
@@ -160,49 +210,3 @@ type ServiceInstance struct {
 	IP       string
 	Port     int
 }
-
-type UAAClient struct {
-	BaseURL string
-	Name    string
-	Secret  string
-}
-
-func (c *UAAClient) GetToken() (string, error) {
-	bodyString := "grant_type=client_credentials"
-	request, err := http.NewRequest("POST", c.BaseURL, strings.NewReader(bodyString))
-	request.SetBasicAuth(c.Name, c.Secret)
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	type getTokenResponse struct {
-		AccessToken string `json:"access_token"`
-	}
-	response := &getTokenResponse{}
-	err = c.makeRequest(request, response)
-	if err != nil {
-		return "", err
-	}
-	return response.AccessToken, nil
-}
-
-func (c *UAAClient) makeRequest(request *http.Request, response interface{}) error {
-	resp, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return fmt.Errorf("http client: %s", err)
-	}
-	defer resp.Body.Close()
-
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read body: %s", err)
-	}
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("bad uaa response, code %d, msg %s", resp.StatusCode, string(respBytes))
-	}
-
-	err = json.Unmarshal(respBytes, &response)
-	if err != nil {
-		return fmt.Errorf("unmarshal json: %s", err)
-	}
-	return nil
-}
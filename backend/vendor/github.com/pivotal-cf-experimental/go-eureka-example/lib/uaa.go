@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenExpirySkew is how far ahead of a token's actual expiry
+// GetToken starts treating it as stale, so a refresh has time to complete
+// before the old token is rejected.
+const DefaultTokenExpirySkew = 30 * time.Second
+
+// Token is an OAuth2 access token as returned by the UAA token endpoint.
+type Token struct {
+	AccessToken string
+	TokenType   string
+}
+
+func (t Token) authorizationHeader() string {
+	tokenType := t.TokenType
+	if tokenType == "" {
+		tokenType = "bearer"
+	}
+	return fmt.Sprintf("%s %s", tokenType, t.AccessToken)
+}
+
+type UAAClient struct {
+	BaseURL string
+	Name    string
+	Secret  string
+
+	// HttpClient is used to call the UAA token endpoint. Defaults to
+	// http.DefaultClient when unset.
+	HttpClient *http.Client
+
+	// ExpirySkew controls how long before a cached token's actual expiry
+	// GetToken treats it as stale and fetches a new one. Defaults to
+	// DefaultTokenExpirySkew when unset.
+	ExpirySkew time.Duration
+
+	mu        sync.Mutex
+	token     Token
+	expiresAt time.Time
+	inFlight  *tokenFetch
+}
+
+// tokenFetch tracks a single in-flight token request so that concurrent
+// GetToken calls share one round trip to UAA instead of each firing their
+// own.
+type tokenFetch struct {
+	done  chan struct{}
+	token Token
+	err   error
+}
+
+// GetToken returns a cached access token, refreshing it from UAA only once
+// it's within ExpirySkew of expiry. Concurrent callers that all observe a
+// stale token share a single in-flight refresh.
+func (c *UAAClient) GetToken() (Token, error) {
+	c.mu.Lock()
+	if c.token.AccessToken != "" && time.Now().Add(c.expirySkew()).Before(c.expiresAt) {
+		token := c.token
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	if fetch := c.inFlight; fetch != nil {
+		c.mu.Unlock()
+		<-fetch.done
+		return fetch.token, fetch.err
+	}
+
+	fetch := &tokenFetch{done: make(chan struct{})}
+	c.inFlight = fetch
+	c.mu.Unlock()
+
+	token, expiresIn, err := c.fetchToken()
+
+	c.mu.Lock()
+	c.inFlight = nil
+	if err == nil {
+		c.token = token
+		c.expiresAt = time.Now().Add(expiresIn)
+	}
+	c.mu.Unlock()
+
+	fetch.token = token
+	fetch.err = err
+	close(fetch.done)
+
+	return token, err
+}
+
+func (c *UAAClient) expirySkew() time.Duration {
+	if c.ExpirySkew == 0 {
+		return DefaultTokenExpirySkew
+	}
+	return c.ExpirySkew
+}
+
+func (c *UAAClient) httpClient() *http.Client {
+	if c.HttpClient == nil {
+		return http.DefaultClient
+	}
+	return c.HttpClient
+}
+
+func (c *UAAClient) fetchToken() (Token, time.Duration, error) {
+	bodyString := "grant_type=client_credentials"
+	request, err := http.NewRequest("POST", c.BaseURL, strings.NewReader(bodyString))
+	if err != nil {
+		return Token{}, 0, err
+	}
+	request.SetBasicAuth(c.Name, c.Secret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	type getTokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	response := &getTokenResponse{}
+	if err := c.makeRequest(request, response); err != nil {
+		return Token{}, 0, err
+	}
+
+	token := Token{AccessToken: response.AccessToken, TokenType: response.TokenType}
+	return token, time.Duration(response.ExpiresIn) * time.Second, nil
+}
+
+func (c *UAAClient) makeRequest(request *http.Request, response interface{}) error {
+	resp, err := c.httpClient().Do(request)
+	if err != nil {
+		return fmt.Errorf("http client: %s", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %s", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("bad uaa response, code %d, msg %s", resp.StatusCode, string(respBytes))
+	}
+
+	err = json.Unmarshal(respBytes, &response)
+	if err != nil {
+		return fmt.Errorf("unmarshal json: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func tokenServer(expiresIn int, requestCount *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requestCount, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, atomic.LoadInt32(requestCount), expiresIn)
+	}))
+}
+
+// TestGetTokenCachesUntilExpirySkew verifies that GetToken reuses a cached
+// token until it's within ExpirySkew of its actual expiry, and fetches a
+// fresh one once past that point.
+func TestGetTokenCachesUntilExpirySkew(t *testing.T) {
+	var requestCount int32
+	server := tokenServer(1, &requestCount)
+	defer server.Close()
+
+	c := &UAAClient{BaseURL: server.URL, HttpClient: http.DefaultClient, ExpirySkew: 300 * time.Millisecond}
+
+	token, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken: %s", err)
+	}
+	if token.AccessToken != "token-1" {
+		t.Fatalf("token = %q, want token-1", token.AccessToken)
+	}
+
+	token, err = c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken: %s", err)
+	}
+	if token.AccessToken != "token-1" {
+		t.Errorf("token = %q, want cached token-1 (still well outside the 300ms skew)", token.AccessToken)
+	}
+	if got := atomic.LoadInt32(&requestCount); got != 1 {
+		t.Errorf("token requests = %d, want 1 (second GetToken should have used the cache)", got)
+	}
+
+	time.Sleep(800 * time.Millisecond)
+
+	token, err = c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken: %s", err)
+	}
+	if token.AccessToken == "token-1" {
+		t.Errorf("token = %q, want a refreshed token once past expiry skew", token.AccessToken)
+	}
+	if got := atomic.LoadInt32(&requestCount); got != 2 {
+		t.Errorf("token requests = %d, want 2 after the cached token went stale", got)
+	}
+}
+
+// TestGetTokenSingleflight verifies that concurrent GetToken calls
+// observing a stale token share a single in-flight refresh rather than
+// each firing their own request to UAA.
+func TestGetTokenSingleflight(t *testing.T) {
+	var requestCount int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"shared-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	c := &UAAClient{BaseURL: server.URL, HttpClient: http.DefaultClient}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make([]Token, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.GetToken()
+		}(i)
+	}
+
+	// Give every goroutine a chance to observe the stale token and join
+	// the in-flight fetch before the server responds.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&requestCount); got != 1 {
+		t.Errorf("token requests = %d, want 1 (concurrent callers should share one fetch)", got)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("GetToken[%d]: %s", i, err)
+		}
+		if results[i].AccessToken != "shared-token" {
+			t.Errorf("GetToken[%d] = %q, want shared-token", i, results[i].AccessToken)
+		}
+	}
+}